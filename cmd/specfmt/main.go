@@ -1,5 +1,6 @@
-// Command specfmt refactors OpenAPI 3.0 YAML specs to extract inline response
-// schemas into named components/schemas for better code generation.
+// Command specfmt refactors OpenAPI 3.0/3.1 YAML specs: bundling external
+// $ref references and extracting inline schemas into named
+// components/schemas for better code generation.
 package main
 
 import (
@@ -11,10 +12,15 @@ import (
 )
 
 type FormatCmd struct {
-	Input   string `arg:"" name:"input" help:"OpenAPI YAML file to format" type:"existingfile"`
-	Output  string `short:"o" help:"Output file (defaults to input for in-place)"`
-	DryRun  bool   `help:"Do not write file, only validate and report changes"`
-	Verbose bool   `short:"v" help:"Verbose logging"`
+	Input               string `arg:"" name:"input" help:"OpenAPI YAML file to format" type:"existingfile"`
+	Output              string `short:"o" help:"Output file (defaults to input for in-place)"`
+	DryRun              bool   `help:"Do not write file, only validate and report changes"`
+	Verbose             bool   `short:"v" help:"Verbose logging"`
+	SkipInputValidation bool   `help:"Skip OpenAPI meta-schema validation of the input document"`
+	StrictFingerprint   bool   `help:"Treat schemas differing only in description/example/title as distinct"`
+	Patch               string `help:"Write an RFC 6902 JSON Patch diff of the refactor to this file ('-' for stdout)"`
+	PatchOnly           bool   `help:"Skip writing the refactored YAML; only emit the patch (requires --patch)"`
+	Bundle              bool   `help:"Resolve external $ref references into components/schemas before refactoring"`
 }
 
 func (cmd *FormatCmd) Run() error {
@@ -23,13 +29,35 @@ func (cmd *FormatCmd) Run() error {
 		out = cmd.Input
 	}
 	return specfmt.FormatFile(cmd.Input, out, specfmt.Options{
-		DryRun:  cmd.DryRun,
+		DryRun:              cmd.DryRun,
+		Verbose:             cmd.Verbose,
+		SkipInputValidation: cmd.SkipInputValidation,
+		StrictFingerprint:   cmd.StrictFingerprint,
+		PatchOutput:         cmd.Patch,
+		PatchOnly:           cmd.PatchOnly,
+		Bundle:              cmd.Bundle,
+	})
+}
+
+type BundleCmd struct {
+	Input   string `arg:"" name:"input" help:"OpenAPI YAML file to bundle" type:"existingfile"`
+	Output  string `short:"o" help:"Output file (defaults to input for in-place)"`
+	Verbose bool   `short:"v" help:"Verbose logging"`
+}
+
+func (cmd *BundleCmd) Run() error {
+	out := cmd.Output
+	if out == "" {
+		out = cmd.Input
+	}
+	return specfmt.BundleFile(cmd.Input, out, specfmt.Options{
 		Verbose: cmd.Verbose,
 	})
 }
 
 type CLI struct {
-	Format FormatCmd `cmd:"" help:"Refactor inline response schemas into components/schemas."`
+	Format FormatCmd `cmd:"" help:"Refactor inline schemas into components/schemas."`
+	Bundle BundleCmd `cmd:"" help:"Resolve external $ref references into local components/schemas."`
 }
 
 func main() {
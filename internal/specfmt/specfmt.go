@@ -1,14 +1,27 @@
-// Package specfmt provides utilities for refactoring OpenAPI 3.0 YAML specs
-// to extract inline schemas into named components/schemas.
+// Package specfmt provides utilities for refactoring OpenAPI 3.0/3.1 YAML
+// specs: bundling external $ref references into the document and extracting
+// inline schemas (in responses, request bodies, parameters, headers, and
+// callbacks) into named components/schemas.
 package specfmt
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"unicode"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/getkin/kin-openapi/openapi3"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,13 +30,27 @@ type schemaRegistry struct {
 	byFingerprint map[string]string
 	existingNames map[string]struct{}
 	schemasNode   *yaml.Node
+	strict        bool
+
+	// specVersion is the detected OpenAPI major.minor version of the
+	// document this registry was built for; it governs 3.1-only behavior
+	// like webhooks and the nullable type-array form.
+	specVersion specVersion
+
+	// unknownDialect is set when the document declares a jsonSchemaDialect
+	// other than the OAS 3.1 base dialect. We don't understand arbitrary
+	// dialects, so componentizeSchema becomes a no-op rather than risk
+	// mangling schemas written against rules we can't account for.
+	unknownDialect bool
 }
 
-func newSchemaRegistry(schemas *yaml.Node) *schemaRegistry {
+func newSchemaRegistry(schemas *yaml.Node, opts Options, version specVersion) *schemaRegistry {
 	r := &schemaRegistry{
 		byFingerprint: make(map[string]string),
 		existingNames: make(map[string]struct{}),
 		schemasNode:   schemas,
+		strict:        opts.StrictFingerprint,
+		specVersion:   version,
 	}
 	if schemas.Kind == yaml.MappingNode {
 		for i := 0; i < len(schemas.Content); i += 2 {
@@ -31,7 +58,7 @@ func newSchemaRegistry(schemas *yaml.Node) *schemaRegistry {
 			schemaNode := schemas.Content[i+1]
 			name := nameNode.Value
 			r.existingNames[name] = struct{}{}
-			fp := schemaFingerprint(schemaNode)
+			fp := schemaFingerprint(schemaNode, r.strict, r.specVersion)
 			if fp != "" {
 				r.byFingerprint[fp] = name
 			}
@@ -40,28 +67,184 @@ func newSchemaRegistry(schemas *yaml.Node) *schemaRegistry {
 	return r
 }
 
-func schemaFingerprint(n *yaml.Node) string {
-	var b strings.Builder
-	writeNodeFingerprint(&b, n)
-	return b.String()
+// oas31BaseDialect is the default JSON Schema dialect OpenAPI 3.1 documents
+// use when jsonSchemaDialect is absent.
+const oas31BaseDialect = "https://spec.openapis.org/oas/3.1/dialect/base"
+
+// applyJSONSchemaDialect records whether the document opts into a
+// jsonSchemaDialect we don't understand, in which case componentizeSchema
+// becomes a no-op for the lifetime of this registry.
+func (r *schemaRegistry) applyJSONSchemaDialect(dialectNode *yaml.Node, opts Options) {
+	if dialectNode == nil || dialectNode.Kind != yaml.ScalarNode || dialectNode.Value == "" {
+		return
+	}
+	if dialectNode.Value == oas31BaseDialect {
+		return
+	}
+	r.unknownDialect = true
+	if opts.Verbose {
+		fmt.Printf("jsonSchemaDialect %q is not the OAS 3.1 base dialect; skipping schema componentization\n", dialectNode.Value)
+	}
 }
 
-func writeNodeFingerprint(b *strings.Builder, n *yaml.Node) {
+// specVersion is the detected OpenAPI document version family.
+type specVersion int
+
+const (
+	specVersionUnknown specVersion = iota
+	specVersion30
+	specVersion31
+)
+
+// detectSpecVersion reads the top-level `openapi:` field to tell 3.0 and 3.1
+// documents apart; behavior that differs between the two (optional paths,
+// webhooks, jsonSchemaDialect) branches on this.
+func detectSpecVersion(top *yaml.Node) specVersion {
+	v := getMapValue(top, "openapi")
+	if v == nil || v.Kind != yaml.ScalarNode {
+		return specVersionUnknown
+	}
+	switch {
+	case strings.HasPrefix(v.Value, "3.1"):
+		return specVersion31
+	case strings.HasPrefix(v.Value, "3.0"):
+		return specVersion30
+	default:
+		return specVersionUnknown
+	}
+}
+
+// cosmeticFingerprintFields are keys ignored when computing a schema
+// fingerprint unless Options.StrictFingerprint asks for an exact match;
+// schemas that differ only in these are still the same schema.
+var cosmeticFingerprintFields = map[string]struct{}{
+	"description": {},
+	"example":     {},
+	"examples":    {},
+	"title":       {},
+}
+
+// schemaKeywordContainers are keys whose value is a mapping of arbitrary
+// names (property names, regex patterns, definition names) rather than
+// schema keywords. Cosmetic-field skipping must not apply to that mapping's
+// own keys: a property can genuinely be named "description" or "title", and
+// eliding it would merge two structurally different schemas.
+var schemaKeywordContainers = map[string]struct{}{
+	"properties":        {},
+	"patternProperties": {},
+	"$defs":             {},
+	"definitions":       {},
+}
+
+// booleanFingerprintKeywords are keys whose value is boolean per the
+// OpenAPI/JSON Schema spec even when authored as a quoted string; a
+// fingerprint must treat `nullable: true` and `nullable: "true"` as the same
+// schema rather than hashing the YAML scalar tag verbatim.
+var booleanFingerprintKeywords = map[string]struct{}{
+	"nullable":    {},
+	"readOnly":    {},
+	"writeOnly":   {},
+	"deprecated":  {},
+	"uniqueItems": {},
+}
+
+// exclusiveBoundsBooleanKeywords are boolean only in OpenAPI 3.0's JSON
+// Schema subset, where they pair with a separate minimum/maximum. OpenAPI
+// 3.1 adopted plain JSON Schema, where exclusiveMinimum/exclusiveMaximum are
+// themselves the numeric bound; normalizing `exclusiveMinimum: 1` to a bool
+// there would collide it with `exclusiveMinimum: true` and with `: 0`.
+var exclusiveBoundsBooleanKeywords = map[string]struct{}{
+	"exclusiveMinimum": {},
+	"exclusiveMaximum": {},
+}
+
+// schemaFingerprint computes a canonical, key-order-independent fingerprint
+// for a schema node so that two schemas written with differently-ordered
+// object keys dedupe to the same components/schemas entry. strict disables
+// the cosmetic-field skip (see cosmeticFingerprintFields), for callers that
+// want doc-only edits to count as a different schema. version governs
+// version-dependent scalar normalization (see exclusiveBoundsBooleanKeywords).
+func schemaFingerprint(n *yaml.Node, strict bool, version specVersion) string {
+	h := sha256.New()
+	writeNodeFingerprint(h, n, "", strict, true, version)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeNodeFingerprint hashes n canonically. isSchemaKeywords says whether
+// n's own mapping keys (if any) are schema keywords subject to the
+// cosmetic-field skip, as opposed to arbitrary names (property names, regex
+// patterns, $defs names) reached via a schemaKeywordContainers key one level
+// up, which must always be hashed verbatim.
+func writeNodeFingerprint(h hash.Hash, n *yaml.Node, key string, strict bool, isSchemaKeywords bool, version specVersion) {
 	if n == nil {
-		b.WriteString("nil;")
+		io.WriteString(h, "nil;")
 		return
 	}
-	fmt.Fprintf(b, "K:%d;T:%s;V:%q;", n.Kind, n.Tag, n.Value)
-	if len(n.Content) > 0 {
-		b.WriteString("[")
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		type pair struct {
+			key string
+			val *yaml.Node
+		}
+		pairs := make([]pair, 0, len(n.Content)/2)
+		for i := 0; i < len(n.Content); i += 2 {
+			k := n.Content[i].Value
+			if isSchemaKeywords && !strict {
+				if _, cosmetic := cosmeticFingerprintFields[k]; cosmetic {
+					continue
+				}
+			}
+			pairs = append(pairs, pair{k, n.Content[i+1]})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+		fmt.Fprintf(h, "M[%d]{", len(pairs))
+		for _, p := range pairs {
+			fmt.Fprintf(h, "%q:", p.key)
+			_, isContainer := schemaKeywordContainers[p.key]
+			writeNodeFingerprint(h, p.val, p.key, strict, !isContainer, version)
+			io.WriteString(h, ",")
+		}
+		io.WriteString(h, "}")
+
+	case yaml.SequenceNode:
+		// Arrays are semantically ordered in JSON Schema, so sequence order
+		// is preserved (unlike mapping keys, which are sorted above). Array
+		// elements (allOf/anyOf/oneOf members, items when tuple-typed) are
+		// themselves schema objects.
+		fmt.Fprintf(h, "S[%d][", len(n.Content))
 		for _, c := range n.Content {
-			writeNodeFingerprint(b, c)
-			b.WriteString("|")
+			writeNodeFingerprint(h, c, "", strict, true, version)
+			io.WriteString(h, "|")
 		}
-		b.WriteString("]")
+		io.WriteString(h, "]")
+
+	case yaml.ScalarNode:
+		writeScalarFingerprint(h, n, key, version)
+
+	default:
+		fmt.Fprintf(h, "K:%d;T:%s;V:%q;", n.Kind, n.Tag, n.Value)
 	}
 }
 
+// writeScalarFingerprint hashes a scalar node, normalizing known boolean
+// keywords so that e.g. `nullable: true` and `nullable: "true"` fingerprint
+// identically regardless of the YAML tag the author happened to write.
+func writeScalarFingerprint(h hash.Hash, n *yaml.Node, key string, version specVersion) {
+	_, boolKeyword := booleanFingerprintKeywords[key]
+	if !boolKeyword && version == specVersion30 {
+		_, boolKeyword = exclusiveBoundsBooleanKeywords[key]
+	}
+	if boolKeyword {
+		if b, err := strconv.ParseBool(n.Value); err == nil {
+			fmt.Fprintf(h, "bool:%t;", b)
+			return
+		}
+	}
+	fmt.Fprintf(h, "T:%s;V:%q;", n.Tag, n.Value)
+}
+
 func (r *schemaRegistry) componentizeSchema(schemaNode *yaml.Node, nameHint string, opts Options) (string, bool, error) {
 	if schemaNode == nil || schemaNode.Kind != yaml.MappingNode {
 		return "", false, nil
@@ -69,8 +252,11 @@ func (r *schemaRegistry) componentizeSchema(schemaNode *yaml.Node, nameHint stri
 	if isRefOnlySchema(schemaNode) {
 		return "", false, nil
 	}
+	if r.unknownDialect {
+		return "", false, nil
+	}
 
-	fp := schemaFingerprint(schemaNode)
+	fp := schemaFingerprint(schemaNode, r.strict, r.specVersion)
 
 	if existingName, ok := r.byFingerprint[fp]; ok {
 		makeRefOnlySchema(schemaNode, existingName)
@@ -101,7 +287,7 @@ func (r *schemaRegistry) componentizeSchema(schemaNode *yaml.Node, nameHint stri
 
 func (r *schemaRegistry) ensureUniqueName(base, fp string) string {
 	if existing := getMapValue(r.schemasNode, base); existing != nil {
-		if schemaFingerprint(existing) == fp {
+		if schemaFingerprint(existing, r.strict, r.specVersion) == fp {
 			return base
 		}
 	}
@@ -121,11 +307,62 @@ func (r *schemaRegistry) ensureUniqueName(base, fp string) string {
 type Options struct {
 	DryRun  bool
 	Verbose bool
+
+	// SkipInputValidation skips OpenAPI meta-schema validation of the input
+	// document. By default FormatFile refuses to refactor a spec that
+	// doesn't validate, since the refactor's own invariants (componentized
+	// schemas resolving, no name clashes) only mean something if the input
+	// was sound to begin with. This does not waive output validation: the
+	// refactored document is still validated regardless, since a refactor
+	// that produces an invalid document is a bug in this package, not in
+	// the user's spec, and deserves to surface even when the input wasn't
+	// checked. A user relying on SkipInputValidation because their input
+	// doesn't fully validate should expect FormatFile to still reject it
+	// post-refactor for the same reason.
+	//
+	// OpenAPI 3.1 documents always skip both passes regardless of this
+	// flag: the pinned kin-openapi validator predates 3.1 (it rejects
+	// `openapi: 3.1.x` and constructs like `type: [object, "null"]`
+	// outright), so it cannot validate one either way.
+	SkipInputValidation bool
+
+	// StrictFingerprint makes schema deduplication exact: two inline schemas
+	// that differ only in description/example/title are treated as
+	// different schemas instead of being merged into one component.
+	StrictFingerprint bool
+
+	// PatchOutput, if set, writes an RFC 6902 JSON Patch diff between the
+	// original and refactored documents to this path ("-" for stdout).
+	// Useful for review and for PR bots that want to comment the patch
+	// instead of a raw YAML diff.
+	PatchOutput string
+
+	// PatchOnly skips writing the refactored YAML entirely; only the patch
+	// from PatchOutput is produced. Requires PatchOutput to be set.
+	PatchOnly bool
+
+	// Bundle resolves external $ref references (to other files or remote
+	// URLs) into local components/schemas entries before refactoring. See
+	// Bundle.
+	Bundle bool
 }
 
-// FormatFile reads an OpenAPI YAML file, refactors inline response schemas
-// into components/schemas, and writes the result to outPath.
+// FormatFile reads an OpenAPI YAML file, refactors inline schemas into
+// components/schemas, and writes the result to outPath.
+//
+// Both the input and the refactored output are validated against the
+// OpenAPI 3.0 meta-schema via kin-openapi. Input validation can be disabled
+// with Options.SkipInputValidation; output validation cannot be, and now
+// always runs (not just when the refactor reports a change), so an
+// already-invalid input that happens not to need refactoring doesn't slip
+// through unvalidated just because SkipInputValidation was set. Neither
+// pass runs for an OpenAPI 3.1 document: the pinned kin-openapi can't
+// validate one at all.
 func FormatFile(inPath, outPath string, opts Options) error {
+	if opts.PatchOnly && opts.PatchOutput == "" {
+		return fmt.Errorf("PatchOnly requires PatchOutput to be set")
+	}
+
 	f, err := os.Open(inPath)
 	if err != nil {
 		return fmt.Errorf("open input: %w", err)
@@ -137,11 +374,63 @@ func FormatFile(inPath, outPath string, opts Options) error {
 		return err
 	}
 
-	changed, err := RefactorInlineResponseSchemas(root, opts)
+	if opts.Bundle {
+		if _, err := Bundle(inPath, root, opts); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+
+	version := detectSpecVersion(root.Content[0])
+	validatable := version != specVersion31
+	if !validatable && opts.Verbose {
+		fmt.Println("Skipping OpenAPI validation: this build of kin-openapi does not support OpenAPI 3.1 documents")
+	}
+
+	if validatable && !opts.SkipInputValidation {
+		preBytes, err := marshalYAML(root)
+		if err != nil {
+			return err
+		}
+		if err := validateDocument(ctx, preBytes, "input"); err != nil {
+			return err
+		}
+	}
+
+	var originalJSON []byte
+	if opts.PatchOutput != "" {
+		originalJSON, err = marshalJSON(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	changed, err := RefactorInlineSchemas(root, opts)
 	if err != nil {
 		return err
 	}
 
+	if validatable {
+		postBytes, err := marshalYAML(root)
+		if err != nil {
+			return err
+		}
+		if err := validateDocument(ctx, postBytes, "refactored output"); err != nil {
+			return err
+		}
+	}
+
+	if opts.PatchOutput != "" {
+		if err := writePatch(opts.PatchOutput, originalJSON, root); err != nil {
+			return err
+		}
+	}
+
+	if opts.PatchOnly {
+		return nil
+	}
+
 	if opts.DryRun {
 		if opts.Verbose {
 			fmt.Printf("Dry-run: changes detected = %v\n", changed)
@@ -192,9 +481,125 @@ func writeYAML(w io.Writer, root *yaml.Node) error {
 	return nil
 }
 
+// marshalYAML renders root back to YAML bytes without touching disk, so it
+// can be fed to the OpenAPI validator both before and after a refactor.
+func marshalYAML(root *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeYAML(&buf, root); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalJSON renders root as JSON, for diffing with github.com/evanphx/json-patch/v5.
+func marshalJSON(root *yaml.Node) ([]byte, error) {
+	var v interface{}
+	if err := root.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decode YAML for JSON patch: %w", err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal JSON for patch: %w", err)
+	}
+	return data, nil
+}
+
+// writePatch computes the RFC 6902 JSON Patch from originalJSON to root's
+// current state and writes it to path ("-" for stdout).
+func writePatch(path string, originalJSON []byte, root *yaml.Node) error {
+	refactoredJSON, err := marshalJSON(root)
+	if err != nil {
+		return err
+	}
+
+	ops, err := jsonpatch.CreatePatch(originalJSON, refactoredJSON)
+	if err != nil {
+		return fmt.Errorf("compute JSON patch: %w", err)
+	}
+
+	patchJSON, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal JSON patch: %w", err)
+	}
+	patchJSON = append(patchJSON, '\n')
+
+	if path == "-" {
+		_, err := os.Stdout.Write(patchJSON)
+		return err
+	}
+	if err := os.WriteFile(path, patchJSON, 0o644); err != nil {
+		return fmt.Errorf("write patch: %w", err)
+	}
+	return nil
+}
+
+// validateDocument parses data as an OpenAPI document and runs it through
+// kin-openapi's full validation, which covers both the OpenAPI 3.0
+// meta-schema and the spec's semantic rules ($ref resolution, required
+// fields, etc). The pinned kin-openapi cannot validate OpenAPI 3.1
+// documents, so FormatFile does not call this for them. label is used to distinguish input-document failures from
+// refactored-output failures in the returned error.
+func validateDocument(ctx context.Context, data []byte, label string) error {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(data)
+	if err != nil {
+		return fmt.Errorf("parse %s spec: %w", label, err)
+	}
+	if err := doc.Validate(ctx); err != nil {
+		return fmt.Errorf("%s spec failed OpenAPI validation: %w", label, withJSONPointer(err))
+	}
+	return nil
+}
+
+// withJSONPointer annotates a kin-openapi validation error with the JSON
+// pointer path to the offending node, when the error carries one, so users
+// can find the broken node without a YAML diff.
+func withJSONPointer(err error) error {
+	var jp interface{ JSONPointer() []string }
+	if errors.As(err, &jp) {
+		return fmt.Errorf("%w (at #/%s)", err, strings.Join(jp.JSONPointer(), "/"))
+	}
+	return err
+}
+
+// refactorTargets selects which parts of the document RefactorInlineSchemas
+// (and its response-only wrapper) should walk.
+type refactorTargets struct {
+	responses     bool
+	requestBodies bool
+	parameters    bool
+	headers       bool
+	callbacks     bool
+	components    bool
+}
+
 // RefactorInlineResponseSchemas walks all paths/operations/responses and
 // extracts inline schemas into components/schemas.
+//
+// This is a thin wrapper around RefactorInlineSchemas that only touches
+// response bodies, kept for callers that don't want request bodies,
+// parameters, headers, or callbacks rewritten.
 func RefactorInlineResponseSchemas(root *yaml.Node, opts Options) (bool, error) {
+	return refactorInlineSchemas(root, opts, refactorTargets{responses: true})
+}
+
+// RefactorInlineSchemas walks all paths/operations and extracts inline
+// schemas from responses, request bodies, parameters (including parameter
+// content schemas), headers, and callbacks into components/schemas. It also
+// walks components/responses, components/requestBodies, components/parameters,
+// and components/headers, since those can contain inline schemas of their own.
+func RefactorInlineSchemas(root *yaml.Node, opts Options) (bool, error) {
+	return refactorInlineSchemas(root, opts, refactorTargets{
+		responses:     true,
+		requestBodies: true,
+		parameters:    true,
+		headers:       true,
+		callbacks:     true,
+		components:    true,
+	})
+}
+
+func refactorInlineSchemas(root *yaml.Node, opts Options, targets refactorTargets) (bool, error) {
 	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
 		return false, fmt.Errorf("expected YAML document")
 	}
@@ -203,16 +608,64 @@ func RefactorInlineResponseSchemas(root *yaml.Node, opts Options) (bool, error)
 		return false, fmt.Errorf("expected top-level mapping")
 	}
 
+	version := detectSpecVersion(top)
+
 	componentsNode := ensureMapValue(top, "components")
 	schemasNode := ensureMapValue(componentsNode, "schemas")
 
-	reg := newSchemaRegistry(schemasNode)
+	reg := newSchemaRegistry(schemasNode, opts, version)
+	reg.applyJSONSchemaDialect(getMapValue(top, "jsonSchemaDialect"), opts)
 
 	pathsNode := getMapValue(top, "paths")
-	if pathsNode == nil || pathsNode.Kind != yaml.MappingNode {
+	switch {
+	case pathsNode != nil && pathsNode.Kind != yaml.MappingNode:
 		return false, fmt.Errorf("missing or invalid 'paths' section")
+	case pathsNode == nil && version != specVersion31:
+		// paths is required in 3.0; in 3.1 it's optional as long as
+		// webhooks or components carry the content instead.
+		return false, fmt.Errorf("missing or invalid 'paths' section")
+	}
+
+	changed := false
+
+	if pathsNode != nil {
+		if c, err := walkPathItems(pathsNode, schemasNode, reg, opts, targets); err != nil {
+			return false, err
+		} else if c {
+			changed = true
+		}
+	}
+
+	if version == specVersion31 {
+		if webhooksNode := getMapValue(top, "webhooks"); webhooksNode != nil && webhooksNode.Kind == yaml.MappingNode {
+			if c, err := walkPathItems(webhooksNode, schemasNode, reg, opts, targets); err != nil {
+				return false, err
+			} else if c {
+				changed = true
+			}
+		}
+	}
+
+	if targets.components {
+		if c, err := walkComponentObjects(componentsNode, schemasNode, reg, opts, targets); err != nil {
+			return false, err
+		} else if c {
+			changed = true
+		}
 	}
 
+	return changed, nil
+}
+
+// walkPathItems iterates path items (or callback expressions, which share
+// the same method-keyed shape) and processes each operation found.
+func walkPathItems(
+	pathsNode *yaml.Node,
+	schemasNode *yaml.Node,
+	reg *schemaRegistry,
+	opts Options,
+	targets refactorTargets,
+) (bool, error) {
 	changed := false
 
 	for i := 0; i < len(pathsNode.Content); i += 2 {
@@ -228,7 +681,7 @@ func RefactorInlineResponseSchemas(root *yaml.Node, opts Options) (bool, error)
 			method := methodKey.Value
 
 			switch method {
-			case "get", "post", "put", "delete", "patch", "options", "head":
+			case "get", "post", "put", "delete", "patch", "options", "head", "trace":
 			default:
 				continue
 			}
@@ -237,35 +690,362 @@ func RefactorInlineResponseSchemas(root *yaml.Node, opts Options) (bool, error)
 				continue
 			}
 
-			opIDNode := getMapValue(methodVal, "operationId")
-			operationID := ""
-			if opIDNode != nil && opIDNode.Kind == yaml.ScalarNode {
-				operationID = opIDNode.Value
+			c, err := processOperation(pathKey.Value, method, methodVal, schemasNode, reg, opts, targets)
+			if err != nil {
+				return false, err
 			}
-
-			respNode := getMapValue(methodVal, "responses")
-			if respNode == nil || respNode.Kind != yaml.MappingNode {
-				continue
+			if c {
+				changed = true
 			}
+		}
+	}
 
+	return changed, nil
+}
+
+// processOperation extracts inline schemas from a single operation object
+// (responses, requestBody, parameters, callbacks), per the given targets.
+func processOperation(
+	path, method string,
+	methodVal *yaml.Node,
+	schemasNode *yaml.Node,
+	reg *schemaRegistry,
+	opts Options,
+	targets refactorTargets,
+) (bool, error) {
+	opIDNode := getMapValue(methodVal, "operationId")
+	operationID := ""
+	if opIDNode != nil && opIDNode.Kind == yaml.ScalarNode {
+		operationID = opIDNode.Value
+	}
+	opName := deriveSchemaName(operationID)
+	if opName == "" {
+		opName = deriveNameFromPathAndMethod(path, method)
+	}
+
+	changed := false
+
+	if targets.responses {
+		if respNode := getMapValue(methodVal, "responses"); respNode != nil && respNode.Kind == yaml.MappingNode {
 			for k := 0; k < len(respNode.Content); k += 2 {
 				codeKey := respNode.Content[k]
 				codeVal := respNode.Content[k+1]
 
-				changedHere, err := processResponseSchema(
-					pathKey.Value, method, operationID, codeKey.Value,
+				c, err := processResponseSchema(
+					path, method, operationID, codeKey.Value,
 					codeVal, schemasNode, reg, opts,
 				)
 				if err != nil {
 					return false, err
 				}
-				if changedHere {
+				if c {
 					changed = true
 				}
+
+				if targets.headers {
+					c, err := processResponseHeaders(codeVal, opName+toPascalCase(codeKey.Value), reg, opts)
+					if err != nil {
+						return false, err
+					}
+					if c {
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	if targets.requestBodies {
+		c, err := processRequestBody(methodVal, opName, reg, opts)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	if targets.parameters {
+		c, err := processParameters(getMapValue(methodVal, "parameters"), opName, reg, opts)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	if targets.callbacks {
+		c, err := processCallbacks(getMapValue(methodVal, "callbacks"), schemasNode, reg, opts, targets)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// processCallbacks walks callbacks.<name>.<expression>, each of which has the
+// same method-keyed shape as a path item.
+func processCallbacks(
+	callbacksNode *yaml.Node,
+	schemasNode *yaml.Node,
+	reg *schemaRegistry,
+	opts Options,
+	targets refactorTargets,
+) (bool, error) {
+	if callbacksNode == nil || callbacksNode.Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	changed := false
+	for i := 0; i < len(callbacksNode.Content); i += 2 {
+		callbackNode := callbacksNode.Content[i+1]
+		if callbackNode.Kind != yaml.MappingNode || isRefOnlySchema(callbackNode) {
+			continue
+		}
+		c, err := walkPathItems(callbackNode, schemasNode, reg, opts, targets)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// walkComponentObjects walks components/responses, components/requestBodies,
+// components/parameters, and components/headers, each of which holds objects
+// of the same shape found inline under an operation.
+func walkComponentObjects(
+	componentsNode *yaml.Node,
+	schemasNode *yaml.Node,
+	reg *schemaRegistry,
+	opts Options,
+	targets refactorTargets,
+) (bool, error) {
+	changed := false
+
+	if respNode := getMapValue(componentsNode, "responses"); respNode != nil && respNode.Kind == yaml.MappingNode {
+		for i := 0; i < len(respNode.Content); i += 2 {
+			name := respNode.Content[i].Value
+			c, err := processResponseSchema("", "", "", name, respNode.Content[i+1], schemasNode, reg, opts)
+			if err != nil {
+				return false, err
+			}
+			if c {
+				changed = true
+			}
+			if c, err := processResponseHeaders(respNode.Content[i+1], toPascalCase(name), reg, opts); err != nil {
+				return false, err
+			} else if c {
+				changed = true
+			}
+		}
+	}
+
+	if rbNode := getMapValue(componentsNode, "requestBodies"); rbNode != nil && rbNode.Kind == yaml.MappingNode {
+		for i := 0; i < len(rbNode.Content); i += 2 {
+			name := rbNode.Content[i].Value
+			rb := rbNode.Content[i+1]
+			if rb.Kind != yaml.MappingNode || isRefOnlySchema(rb) {
+				continue
+			}
+			c, err := processContentMap(getMapValue(rb, "content"), toPascalCase(name)+"RequestBody", reg, opts)
+			if err != nil {
+				return false, err
+			}
+			if c {
+				changed = true
+			}
+		}
+	}
+
+	if paramsNode := getMapValue(componentsNode, "parameters"); paramsNode != nil && paramsNode.Kind == yaml.MappingNode {
+		for i := 0; i < len(paramsNode.Content); i += 2 {
+			name := paramsNode.Content[i].Value
+			c, err := processParameter(paramsNode.Content[i+1], toPascalCase(name)+"Parameter", reg, opts)
+			if err != nil {
+				return false, err
+			}
+			if c {
+				changed = true
 			}
 		}
 	}
 
+	if headersNode := getMapValue(componentsNode, "headers"); headersNode != nil && headersNode.Kind == yaml.MappingNode {
+		c, err := processHeaders(headersNode, "", reg, opts)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+
+	return changed, nil
+}
+
+// isJSONContentType reports whether a media type such as
+// "application/json; charset=UTF-8" or "application/vnd.api+json" should be
+// treated as JSON for schema extraction purposes. oapi-codegen generates
+// stubs for any "*/json" or "*+json" media type, not just "application/json".
+func isJSONContentType(contentType string) bool {
+	ct := contentType
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	ct = strings.TrimSpace(ct)
+	return strings.HasSuffix(ct, "/json") || strings.HasSuffix(ct, "+json")
+}
+
+// findJSONContent returns the first media type entry under a content map
+// that matches isJSONContentType, along with its key.
+func findJSONContent(contentNode *yaml.Node) (string, *yaml.Node) {
+	if contentNode == nil || contentNode.Kind != yaml.MappingNode {
+		return "", nil
+	}
+	for i := 0; i < len(contentNode.Content); i += 2 {
+		k := contentNode.Content[i]
+		v := contentNode.Content[i+1]
+		if isJSONContentType(k.Value) {
+			return k.Value, v
+		}
+	}
+	return "", nil
+}
+
+// processContentMap extracts inline schemas from every JSON media type entry
+// in a content map (requestBody.content, parameter.content, header.content).
+func processContentMap(contentNode *yaml.Node, nameHint string, reg *schemaRegistry, opts Options) (bool, error) {
+	if contentNode == nil || contentNode.Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	changed := false
+	for i := 0; i < len(contentNode.Content); i += 2 {
+		mediaType := contentNode.Content[i].Value
+		mediaNode := contentNode.Content[i+1]
+		if !isJSONContentType(mediaType) || mediaNode.Kind != yaml.MappingNode {
+			continue
+		}
+
+		schemaNode := getMapValue(mediaNode, "schema")
+		if schemaNode == nil || schemaNode.Kind != yaml.MappingNode || isRefOnlySchema(schemaNode) {
+			continue
+		}
+
+		if opts.Verbose {
+			fmt.Printf("Found inline schema in content -> creating %s\n", nameHint)
+		}
+		if _, ok, err := reg.componentizeSchema(schemaNode, nameHint, opts); err != nil {
+			return false, err
+		} else if ok {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// processRequestBody extracts inline schemas from an operation's requestBody.
+func processRequestBody(methodVal *yaml.Node, opName string, reg *schemaRegistry, opts Options) (bool, error) {
+	rbNode := getMapValue(methodVal, "requestBody")
+	if rbNode == nil || rbNode.Kind != yaml.MappingNode || isRefOnlySchema(rbNode) {
+		return false, nil
+	}
+	return processContentMap(getMapValue(rbNode, "content"), opName+"RequestBody", reg, opts)
+}
+
+// processParameters extracts inline schemas from an operation's parameter list.
+func processParameters(paramsNode *yaml.Node, opName string, reg *schemaRegistry, opts Options) (bool, error) {
+	if paramsNode == nil || paramsNode.Kind != yaml.SequenceNode {
+		return false, nil
+	}
+
+	changed := false
+	for _, paramNode := range paramsNode.Content {
+		paramNameNode := getMapValue(paramNode, "name")
+		paramName := ""
+		if paramNameNode != nil {
+			paramName = paramNameNode.Value
+		}
+		nameHint := opName + toPascalCase(paramName) + "Parameter"
+
+		c, err := processParameter(paramNode, nameHint, reg, opts)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// processParameter extracts the inline schema from a single parameter object,
+// whether it uses the "schema" shorthand or the "content" map form.
+func processParameter(paramNode *yaml.Node, nameHint string, reg *schemaRegistry, opts Options) (bool, error) {
+	if paramNode.Kind != yaml.MappingNode || isRefOnlySchema(paramNode) {
+		return false, nil
+	}
+
+	changed := false
+
+	if schemaNode := getMapValue(paramNode, "schema"); schemaNode != nil &&
+		schemaNode.Kind == yaml.MappingNode && !isRefOnlySchema(schemaNode) {
+		if opts.Verbose {
+			fmt.Printf("Found inline schema on parameter -> creating %s\n", nameHint)
+		}
+		if _, ok, err := reg.componentizeSchema(schemaNode, nameHint, opts); err != nil {
+			return false, err
+		} else if ok {
+			changed = true
+		}
+	}
+
+	if c, err := processContentMap(getMapValue(paramNode, "content"), nameHint, reg, opts); err != nil {
+		return false, err
+	} else if c {
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// processResponseHeaders extracts inline schemas from a response object's
+// headers map.
+func processResponseHeaders(responseNode *yaml.Node, nameHint string, reg *schemaRegistry, opts Options) (bool, error) {
+	if responseNode.Kind != yaml.MappingNode || isRefOnlySchema(responseNode) {
+		return false, nil
+	}
+	return processHeaders(getMapValue(responseNode, "headers"), nameHint, reg, opts)
+}
+
+// processHeaders extracts inline schemas from a headers map, whether it
+// belongs to a response or to components/headers directly.
+func processHeaders(headersNode *yaml.Node, nameHint string, reg *schemaRegistry, opts Options) (bool, error) {
+	if headersNode == nil || headersNode.Kind != yaml.MappingNode {
+		return false, nil
+	}
+
+	changed := false
+	for i := 0; i < len(headersNode.Content); i += 2 {
+		headerName := headersNode.Content[i].Value
+		headerHint := nameHint + toPascalCase(headerName) + "Header"
+
+		c, err := processParameter(headersNode.Content[i+1], headerHint, reg, opts)
+		if err != nil {
+			return false, err
+		}
+		if c {
+			changed = true
+		}
+	}
 	return changed, nil
 }
 
@@ -285,17 +1065,7 @@ func processResponseSchema(
 		return false, nil
 	}
 
-	var appJSON *yaml.Node
-	for _, contentType := range []string{
-		"application/json; charset=UTF-8",
-		"application/json",
-		"application/json; charset=utf-8",
-	} {
-		appJSON = getMapValue(contentNode, contentType)
-		if appJSON != nil {
-			break
-		}
-	}
+	_, appJSON := findJSONContent(contentNode)
 	if appJSON == nil || appJSON.Kind != yaml.MappingNode {
 		return false, nil
 	}
@@ -339,6 +1109,10 @@ func handleAlternativesPattern(
 	reg *schemaRegistry,
 	opts Options,
 ) (bool, error) {
+	if reg.unknownDialect {
+		return false, nil
+	}
+
 	baseName := deriveSchemaName(operationID)
 	if baseName == "" {
 		nameHint := deriveResponseSchemaNameHint(path, method, operationID, status)
@@ -361,7 +1135,7 @@ func handleAlternativesPattern(
 		baseSchemaNode := cloneNode(baseNode)
 		appendMapEntry(schemasNode, baseName, baseSchemaNode)
 		reg.existingNames[baseName] = struct{}{}
-		reg.byFingerprint[schemaFingerprint(baseSchemaNode)] = baseName
+		reg.byFingerprint[schemaFingerprint(baseSchemaNode, reg.strict, reg.specVersion)] = baseName
 		if opts.Verbose {
 			fmt.Printf("  Created components/schemas/%s\n", baseName)
 		}
@@ -371,7 +1145,7 @@ func handleAlternativesPattern(
 		compositeSchemaNode := buildCompositeSchema(baseName)
 		appendMapEntry(schemasNode, compositeName, compositeSchemaNode)
 		reg.existingNames[compositeName] = struct{}{}
-		reg.byFingerprint[schemaFingerprint(compositeSchemaNode)] = compositeName
+		reg.byFingerprint[schemaFingerprint(compositeSchemaNode, reg.strict, reg.specVersion)] = compositeName
 		if opts.Verbose {
 			fmt.Printf("  Created components/schemas/%s\n", compositeName)
 		}
@@ -384,19 +1158,21 @@ func handleAlternativesPattern(
 
 func deriveResponseSchemaNameHint(path, method, operationID, status string) string {
 	base := deriveSchemaName(operationID)
-	if base == "" {
+	if base == "" && (path != "" || method != "") {
 		base = deriveNameFromPathAndMethod(path, method)
 	}
+	if base == "" {
+		base = toPascalCase(status)
+		status = ""
+	}
 	if base == "" {
 		base = "Response"
 	}
 
-	statusSuffix := status
-	if statusSuffix == "" {
-		statusSuffix = "Default"
+	if status == "" {
+		return base + "Response"
 	}
-
-	return base + toPascalCase(statusSuffix) + "Response"
+	return base + toPascalCase(status) + "Response"
 }
 
 func deriveNameFromPathAndMethod(path, method string) string {
@@ -423,15 +1199,14 @@ func isInlineObjectWithAlternatives(baseNode, extNode *yaml.Node) bool {
 		return false
 	}
 
-	// Check base is type: object
-	baseType := getMapValue(baseNode, "type")
-	if baseType == nil || baseType.Value != "object" {
+	// Check base is type: object (OpenAPI 3.0 scalar form, or the 3.1
+	// nullable form `type: [object, null]`).
+	if !schemaHasType(baseNode, "object") {
 		return false
 	}
 
 	// Check ext is type: object
-	extType := getMapValue(extNode, "type")
-	if extType == nil || extType.Value != "object" {
+	if !schemaHasType(extNode, "object") {
 		return false
 	}
 
@@ -446,8 +1221,7 @@ func isInlineObjectWithAlternatives(baseNode, extNode *yaml.Node) bool {
 		return false
 	}
 
-	altType := getMapValue(alts, "type")
-	if altType == nil || altType.Value != "array" {
+	if !schemaHasType(alts, "array") {
 		return false
 	}
 
@@ -457,14 +1231,34 @@ func isInlineObjectWithAlternatives(baseNode, extNode *yaml.Node) bool {
 	}
 
 	// items should be type: object (inline) with similar properties
-	itemsType := getMapValue(items, "type")
-	if itemsType == nil || itemsType.Value != "object" {
+	if !schemaHasType(items, "object") {
 		return false
 	}
 
 	return true
 }
 
+// schemaHasType reports whether a schema's `type` keyword includes want,
+// handling both the OpenAPI 3.0 scalar form (`type: object`) and the 3.1
+// form where `type` may be an array of types (`type: [object, null]`).
+func schemaHasType(schemaNode *yaml.Node, want string) bool {
+	typeNode := getMapValue(schemaNode, "type")
+	if typeNode == nil {
+		return false
+	}
+	switch typeNode.Kind {
+	case yaml.ScalarNode:
+		return typeNode.Value == want
+	case yaml.SequenceNode:
+		for _, t := range typeNode.Content {
+			if t.Kind == yaml.ScalarNode && t.Value == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // deriveSchemaName derives a schema name from the operationId.
 // Examples:
 //
@@ -587,6 +1381,290 @@ func buildCompositeSchema(baseName string) *yaml.Node {
 	}
 }
 
+// BundleFile reads an OpenAPI YAML file, resolves external $ref references
+// into local components/schemas entries, and writes the result to outPath.
+func BundleFile(inPath, outPath string, opts Options) error {
+	f, err := os.Open(inPath)
+	if err != nil {
+		return fmt.Errorf("open input: %w", err)
+	}
+	root, err := parseYAML(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	changed, err := Bundle(inPath, root, opts)
+	if err != nil {
+		return err
+	}
+
+	if !changed {
+		if opts.Verbose {
+			fmt.Println("No external refs to bundle")
+		}
+		return nil
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	defer out.Close()
+
+	if err := writeYAML(out, root); err != nil {
+		return err
+	}
+	if opts.Verbose {
+		fmt.Printf("Wrote bundled spec to %s\n", outPath)
+	}
+	return nil
+}
+
+// Bundle resolves $ref references that point outside the document — to
+// other files or remote URLs — into local components/schemas entries,
+// rewriting each one to `#/components/schemas/<Name>`. It uses
+// kin-openapi's loader (with IsExternalRefsAllowed) to fetch and parse the
+// referenced documents, since that's already how this package validates
+// specs elsewhere.
+//
+// Bundle must run before RefactorInlineSchemas: isRefOnlySchema treats any
+// $ref, including an external one, as already extracted, so an
+// unresolved external ref would otherwise survive untouched.
+//
+// Bundle replaces root's contents in place with the bundled document, since
+// resolving refs requires kin-openapi's typed document model rather than
+// the yaml.Node tree the rest of this package operates on.
+func Bundle(inPath string, root *yaml.Node, opts Options) (bool, error) {
+	loader := &openapi3.Loader{IsExternalRefsAllowed: true}
+	doc, err := loader.LoadFromFile(inPath)
+	if err != nil {
+		return false, fmt.Errorf("load spec for bundling: %w", err)
+	}
+
+	if doc.Components == nil {
+		doc.Components = &openapi3.Components{}
+	}
+	if doc.Components.Schemas == nil {
+		doc.Components.Schemas = make(openapi3.Schemas)
+	}
+
+	existingNames := make(map[string]struct{}, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		existingNames[name] = struct{}{}
+	}
+
+	// lifted tracks schemas already bundled by their resolved *Schema
+	// identity, so that two SchemaRefs pointing at the same external target
+	// (which kin-openapi resolves to one shared *Schema) reuse the same
+	// component instead of each minting their own (e.g. "Foo" and "Foo2"
+	// with identical content).
+	lifted := make(map[*openapi3.Schema]string)
+
+	changed := false
+	visitExternalSchemaRefs(doc, func(ref *openapi3.SchemaRef) {
+		if name, ok := lifted[ref.Value]; ok {
+			ref.Value = nil
+			ref.Ref = "#/components/schemas/" + name
+			changed = true
+			return
+		}
+
+		name := ensureUniqueComponentName(bundleNameFromRef(ref.Ref), existingNames)
+		existingNames[name] = struct{}{}
+		lifted[ref.Value] = name
+
+		doc.Components.Schemas[name] = &openapi3.SchemaRef{Value: ref.Value}
+		ref.Value = nil
+		ref.Ref = "#/components/schemas/" + name
+
+		if opts.Verbose {
+			fmt.Printf("Bundled external ref into components/schemas/%s\n", name)
+		}
+		changed = true
+	})
+
+	if !changed {
+		return false, nil
+	}
+
+	data, err := doc.MarshalJSON()
+	if err != nil {
+		return false, fmt.Errorf("marshal bundled spec: %w", err)
+	}
+
+	bundled, err := parseYAML(bytes.NewReader(data))
+	if err != nil {
+		return false, fmt.Errorf("parse bundled spec: %w", err)
+	}
+	*root = *bundled
+
+	return true, nil
+}
+
+// visitExternalSchemaRefs calls fn for every *openapi3.SchemaRef reachable
+// from doc (across paths, request bodies, parameters, responses, headers,
+// and the equivalent components/* objects) whose Ref points outside the
+// document, after kin-openapi's loader has already resolved it into Value.
+//
+// A ref's resolved Value may itself embed further SchemaRefs (properties,
+// items, allOf/anyOf/oneOf/not, additionalProperties) that point outside
+// the document too, so visit descends into those as well; seen guards
+// against revisiting a schema reached through more than one path (or a
+// cycle) once it's already been walked.
+func visitExternalSchemaRefs(doc *openapi3.T, fn func(*openapi3.SchemaRef)) {
+	seen := make(map[*openapi3.Schema]struct{})
+
+	var visit func(ref *openapi3.SchemaRef)
+	visit = func(ref *openapi3.SchemaRef) {
+		if ref == nil {
+			return
+		}
+		// Capture Value before calling fn: fn (the bundler) may clear
+		// ref.Value once it lifts this ref into components/schemas, but we
+		// still need to walk the schema it pointed to for nested refs.
+		value := ref.Value
+		if isExternalRef(ref.Ref) {
+			fn(ref)
+		}
+		if value == nil {
+			return
+		}
+		if _, ok := seen[value]; ok {
+			return
+		}
+		seen[value] = struct{}{}
+
+		for _, propRef := range value.Properties {
+			visit(propRef)
+		}
+		visit(value.Items)
+		if value.AdditionalProperties.Schema != nil {
+			visit(value.AdditionalProperties.Schema)
+		}
+		for _, sub := range value.AllOf {
+			visit(sub)
+		}
+		for _, sub := range value.AnyOf {
+			visit(sub)
+		}
+		for _, sub := range value.OneOf {
+			visit(sub)
+		}
+		visit(value.Not)
+	}
+	visitContent := func(content openapi3.Content) {
+		for _, media := range content {
+			visit(media.Schema)
+		}
+	}
+
+	for _, pathItem := range doc.Paths {
+		for _, op := range pathItem.Operations() {
+			for _, paramRef := range op.Parameters {
+				if paramRef.Value == nil {
+					continue
+				}
+				visit(paramRef.Value.Schema)
+				visitContent(paramRef.Value.Content)
+			}
+			if op.RequestBody != nil && op.RequestBody.Value != nil {
+				visitContent(op.RequestBody.Value.Content)
+			}
+			for _, respRef := range op.Responses {
+				if respRef.Value == nil {
+					continue
+				}
+				visitContent(respRef.Value.Content)
+				for _, headerRef := range respRef.Value.Headers {
+					if headerRef.Value != nil {
+						visit(headerRef.Value.Schema)
+					}
+				}
+			}
+		}
+	}
+
+	if doc.Components == nil {
+		return
+	}
+	for _, schemaRef := range doc.Components.Schemas {
+		visit(schemaRef)
+	}
+	for _, rbRef := range doc.Components.RequestBodies {
+		if rbRef.Value != nil {
+			visitContent(rbRef.Value.Content)
+		}
+	}
+	for _, respRef := range doc.Components.Responses {
+		if respRef.Value != nil {
+			visitContent(respRef.Value.Content)
+		}
+	}
+	for _, paramRef := range doc.Components.Parameters {
+		if paramRef.Value != nil {
+			visit(paramRef.Value.Schema)
+		}
+	}
+	for _, headerRef := range doc.Components.Headers {
+		if headerRef.Value != nil {
+			visit(headerRef.Value.Schema)
+		}
+	}
+}
+
+func isExternalRef(ref string) bool {
+	return ref != "" && !strings.HasPrefix(ref, "#")
+}
+
+// bundleNameFromRef derives a components/schemas name from an external $ref
+// target, e.g. "./schemas/foo.yaml#/Foo" -> "Foo" and
+// "https://example.com/common.yaml" -> "Common".
+func bundleNameFromRef(ref string) string {
+	path := ref
+	fragment := ""
+	if i := strings.IndexByte(ref, '#'); i >= 0 {
+		path = ref[:i]
+		fragment = ref[i+1:]
+	}
+
+	if fragment != "" {
+		parts := strings.Split(strings.Trim(fragment, "/"), "/")
+		if last := parts[len(parts)-1]; last != "" {
+			return toPascalCase(last)
+		}
+	}
+
+	base := path
+	if i := strings.LastIndexAny(base, "/\\"); i >= 0 {
+		base = base[i+1:]
+	}
+	if dot := strings.LastIndexByte(base, '.'); dot > 0 {
+		base = base[:dot]
+	}
+	if base == "" {
+		return "InlineSchema"
+	}
+	return toPascalCase(base)
+}
+
+// ensureUniqueComponentName disambiguates colliding bundle names the same
+// way schemaRegistry.ensureUniqueName does for inline schemas.
+func ensureUniqueComponentName(base string, existing map[string]struct{}) string {
+	if base == "" {
+		base = "InlineSchema"
+	}
+	name := base
+	i := 2
+	for {
+		if _, exists := existing[name]; !exists {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+		i++
+	}
+}
+
 // YAML node helpers
 
 func getMapValue(m *yaml.Node, key string) *yaml.Node {
@@ -0,0 +1,687 @@
+package specfmt
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"gopkg.in/yaml.v3"
+)
+
+func mustParseYAML(t *testing.T, doc string) *yaml.Node {
+	t.Helper()
+	root, err := parseYAML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("parseYAML: %v", err)
+	}
+	return root
+}
+
+func TestSchemaFingerprintIgnoresKeyOrder(t *testing.T) {
+	a := mustParseYAML(t, `
+type: object
+properties:
+  id:
+    type: string
+  name:
+    type: string
+required:
+  - id
+`).Content[0]
+
+	b := mustParseYAML(t, `
+required:
+  - id
+properties:
+  name:
+    type: string
+  id:
+    type: string
+type: object
+`).Content[0]
+
+	fpA := schemaFingerprint(a, false, specVersion30)
+	fpB := schemaFingerprint(b, false, specVersion30)
+	if fpA != fpB {
+		t.Fatalf("fingerprints differ for reordered-but-equivalent schemas:\n%s\n%s", fpA, fpB)
+	}
+}
+
+func TestSchemaFingerprintNormalizesBooleanKeywords(t *testing.T) {
+	a := mustParseYAML(t, `
+type: string
+nullable: true
+`).Content[0]
+
+	b := mustParseYAML(t, `
+type: string
+nullable: "true"
+`).Content[0]
+
+	if schemaFingerprint(a, false, specVersion30) != schemaFingerprint(b, false, specVersion30) {
+		t.Fatal("fingerprints differ for boolean vs quoted-string nullable")
+	}
+}
+
+func TestSchemaFingerprintStrictModeDistinguishesDescriptions(t *testing.T) {
+	a := mustParseYAML(t, `
+type: string
+description: the widget id
+`).Content[0]
+
+	b := mustParseYAML(t, `
+type: string
+description: a different description
+`).Content[0]
+
+	if schemaFingerprint(a, false, specVersion30) != schemaFingerprint(b, false, specVersion30) {
+		t.Fatal("non-strict fingerprints should ignore description differences")
+	}
+	if schemaFingerprint(a, true, specVersion30) == schemaFingerprint(b, true, specVersion30) {
+		t.Fatal("strict fingerprints should distinguish description differences")
+	}
+}
+
+func TestSchemaFingerprintDistinguishesPropertiesNamedLikeCosmeticFields(t *testing.T) {
+	a := mustParseYAML(t, `
+type: object
+properties:
+  description:
+    type: string
+  title:
+    type: integer
+`).Content[0]
+
+	b := mustParseYAML(t, `
+type: object
+properties:
+  description:
+    type: boolean
+  title:
+    type: integer
+`).Content[0]
+
+	if schemaFingerprint(a, false, specVersion30) == schemaFingerprint(b, false, specVersion30) {
+		t.Fatal("fingerprints must differ: schemas have differently-typed properties named description/title, which are not cosmetic fields in this position")
+	}
+}
+
+func TestSchemaFingerprintNormalizesExclusiveBoundsOnlyFor30(t *testing.T) {
+	boolForm := mustParseYAML(t, `
+type: integer
+minimum: 0
+exclusiveMinimum: true
+`).Content[0]
+
+	quotedBoolForm := mustParseYAML(t, `
+type: integer
+minimum: 0
+exclusiveMinimum: "true"
+`).Content[0]
+
+	if schemaFingerprint(boolForm, false, specVersion30) != schemaFingerprint(quotedBoolForm, false, specVersion30) {
+		t.Fatal("OpenAPI 3.0 fingerprints should normalize exclusiveMinimum: true vs \"true\"")
+	}
+
+	numericForm := mustParseYAML(t, `
+type: integer
+exclusiveMinimum: 1
+`).Content[0]
+
+	trueForm := mustParseYAML(t, `
+type: integer
+exclusiveMinimum: true
+`).Content[0]
+
+	if schemaFingerprint(numericForm, false, specVersion31) == schemaFingerprint(trueForm, false, specVersion31) {
+		t.Fatal("OpenAPI 3.1 fingerprints must not normalize exclusiveMinimum as a boolean: exclusiveMinimum is a numeric bound there, so 1 and true are different schemas")
+	}
+}
+
+func TestRefactorInlineSchemasDeduplicatesReorderedSchemas(t *testing.T) {
+	root := mustParseYAML(t, `
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+                  name:
+                    type: string
+  /widgets/{id}/alt:
+    get:
+      operationId: get_widget_alt
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                properties:
+                  name:
+                    type: string
+                  id:
+                    type: string
+                type: object
+`)
+
+	changed, err := RefactorInlineSchemas(root, Options{})
+	if err != nil {
+		t.Fatalf("RefactorInlineSchemas: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected changes")
+	}
+
+	top := root.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil {
+		t.Fatal("expected components/schemas to exist")
+	}
+	if got := len(schemasNode.Content) / 2; got != 1 {
+		t.Fatalf("expected exactly 1 deduplicated component, got %d", got)
+	}
+}
+
+func TestPatchAppliesCleanlyToOriginal(t *testing.T) {
+	root := mustParseYAML(t, `
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+`)
+
+	original, err := marshalJSON(root)
+	if err != nil {
+		t.Fatalf("marshalJSON(original): %v", err)
+	}
+
+	changed, err := RefactorInlineSchemas(root, Options{})
+	if err != nil {
+		t.Fatalf("RefactorInlineSchemas: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected a change")
+	}
+
+	refactored, err := marshalJSON(root)
+	if err != nil {
+		t.Fatalf("marshalJSON(refactored): %v", err)
+	}
+
+	ops, err := jsonpatch.CreatePatch(original, refactored)
+	if err != nil {
+		t.Fatalf("CreatePatch: %v", err)
+	}
+	patchJSON, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatalf("marshal patch: %v", err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(patchJSON)
+	if err != nil {
+		t.Fatalf("DecodePatch: %v", err)
+	}
+	applied, err := patch.Apply(original)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	var appliedVal, refactoredVal interface{}
+	if err := json.Unmarshal(applied, &appliedVal); err != nil {
+		t.Fatalf("unmarshal applied: %v", err)
+	}
+	if err := json.Unmarshal(refactored, &refactoredVal); err != nil {
+		t.Fatalf("unmarshal refactored: %v", err)
+	}
+
+	canonicalApplied, _ := json.Marshal(appliedVal)
+	canonicalRefactored, _ := json.Marshal(refactoredVal)
+	if !bytes.Equal(canonicalApplied, canonicalRefactored) {
+		t.Fatalf("applying the patch to the original did not reproduce the refactored document:\ngot:  %s\nwant: %s",
+			canonicalApplied, canonicalRefactored)
+	}
+}
+
+func TestFormatFileRefactors30SpecToCompletion(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(inPath, []byte(`
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          description: OK
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+`), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	outPath := filepath.Join(dir, "out.yaml")
+	if err := FormatFile(inPath, outPath, Options{}); err != nil {
+		t.Fatalf("FormatFile: %v", err)
+	}
+
+	out := mustParseYAML(t, string(mustReadFile(t, outPath)))
+	top := out.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil || len(schemasNode.Content)/2 != 1 {
+		t.Fatalf("expected exactly 1 extracted component in the written output, got %v", schemasNode)
+	}
+
+	schemaNode := getMapValue(
+		getMapValue(
+			getMapValue(
+				getMapValue(getMapValue(getMapValue(getMapValue(top, "paths"), "/widgets"), "get"), "responses"), "200"),
+			"content"), "application/json")
+	schemaNode = getMapValue(schemaNode, "schema")
+	if schemaNode == nil || !isRefOnlySchema(schemaNode) {
+		t.Fatalf("expected the response schema to be replaced with a $ref, got %v", schemaNode)
+	}
+}
+
+func TestFormatFileRefactors31SpecToCompletion(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(inPath, []byte(`
+openapi: 3.1.0
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: [object, "null"]
+                properties:
+                  id:
+                    type: string
+`), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	outPath := filepath.Join(dir, "out.yaml")
+	if err := FormatFile(inPath, outPath, Options{}); err != nil {
+		t.Fatalf("FormatFile on a 3.1 document should not fail kin-openapi validation it can't perform: %v", err)
+	}
+
+	out := mustParseYAML(t, string(mustReadFile(t, outPath)))
+	top := out.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil || len(schemasNode.Content)/2 != 1 {
+		t.Fatalf("expected exactly 1 extracted component in the written output, got %v", schemasNode)
+	}
+}
+
+func TestFormatFileValidatesOutputEvenWhenUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "spec.yaml")
+	// No inline schemas here for RefactorInlineSchemas to extract, so it
+	// reports no change; output validation must still run and catch that
+	// the operation's responses object is empty.
+	if err := os.WriteFile(inPath, []byte(`
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets:
+    get:
+      operationId: get_widget
+      responses: {}
+`), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	outPath := filepath.Join(dir, "out.yaml")
+	err := FormatFile(inPath, outPath, Options{SkipInputValidation: true})
+	if err == nil {
+		t.Fatal("expected output validation to still reject an empty responses object even though RefactorInlineSchemas found nothing to change")
+	}
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return data
+}
+
+func TestFormatFilePatchOnlyRequiresPatchOutput(t *testing.T) {
+	dir := t.TempDir()
+	inPath := filepath.Join(dir, "spec.yaml")
+	if err := os.WriteFile(inPath, []byte(`
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+`), 0o644); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+
+	err := FormatFile(inPath, inPath, Options{PatchOnly: true})
+	if err == nil {
+		t.Fatal("expected an error when PatchOnly is set without PatchOutput")
+	}
+}
+
+func TestRefactorInlineSchemas31WebhooksWithoutPaths(t *testing.T) {
+	root := mustParseYAML(t, `
+openapi: 3.1.0
+info:
+  title: test
+  version: "1.0"
+webhooks:
+  widgetCreated:
+    post:
+      operationId: widget_created
+      requestBody:
+        content:
+          application/json:
+            schema:
+              type: [object, "null"]
+              properties:
+                id:
+                  type: string
+`)
+
+	changed, err := RefactorInlineSchemas(root, Options{})
+	if err != nil {
+		t.Fatalf("RefactorInlineSchemas: %v", err)
+	}
+	if !changed {
+		t.Fatal("expected webhook request body schema to be extracted")
+	}
+
+	top := root.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil || len(schemasNode.Content)/2 != 1 {
+		t.Fatalf("expected exactly 1 component extracted from the webhook, got %v", schemasNode)
+	}
+}
+
+func TestRefactorInlineSchemasUnknownDialectIsNoOp(t *testing.T) {
+	root := mustParseYAML(t, `
+openapi: 3.1.0
+jsonSchemaDialect: https://example.com/custom-dialect
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  id:
+                    type: string
+`)
+
+	changed, err := RefactorInlineSchemas(root, Options{})
+	if err != nil {
+		t.Fatalf("RefactorInlineSchemas: %v", err)
+	}
+	if changed {
+		t.Fatal("expected no changes under an unrecognized jsonSchemaDialect")
+	}
+}
+
+func TestRefactorInlineSchemasUnknownDialectSkipsAlternativesPattern(t *testing.T) {
+	root := mustParseYAML(t, `
+openapi: 3.1.0
+jsonSchemaDialect: https://example.com/custom-dialect
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                allOf:
+                  - type: object
+                    properties:
+                      id:
+                        type: string
+                  - type: object
+                    properties:
+                      alternatives:
+                        type: array
+                        items:
+                          type: object
+                          properties:
+                            id:
+                              type: string
+`)
+
+	changed, err := RefactorInlineSchemas(root, Options{})
+	if err != nil {
+		t.Fatalf("RefactorInlineSchemas: %v", err)
+	}
+	if changed {
+		t.Fatal("expected the allOf-alternatives pattern to be left untouched under an unrecognized jsonSchemaDialect")
+	}
+}
+
+func TestBundleRewritesTransitiveExternalRefs(t *testing.T) {
+	dir := t.TempDir()
+
+	mainPath := filepath.Join(dir, "main.yaml")
+	fooPath := filepath.Join(dir, "foo.yaml")
+	barPath := filepath.Join(dir, "bar.yaml")
+
+	if err := os.WriteFile(mainPath, []byte(`
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "./foo.yaml#/Foo"
+`), 0o644); err != nil {
+		t.Fatalf("write main.yaml: %v", err)
+	}
+	if err := os.WriteFile(fooPath, []byte(`
+Foo:
+  type: object
+  properties:
+    id:
+      type: string
+    bar:
+      $ref: "./bar.yaml#/Bar"
+`), 0o644); err != nil {
+		t.Fatalf("write foo.yaml: %v", err)
+	}
+	if err := os.WriteFile(barPath, []byte(`
+Bar:
+  type: object
+  properties:
+    label:
+      type: string
+`), 0o644); err != nil {
+		t.Fatalf("write bar.yaml: %v", err)
+	}
+
+	outPath := filepath.Join(dir, "out.yaml")
+	if err := BundleFile(mainPath, outPath, Options{}); err != nil {
+		t.Fatalf("BundleFile: %v", err)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if strings.Contains(string(out), ".yaml") {
+		t.Fatalf("expected no remaining external file refs in bundled output, got:\n%s", out)
+	}
+
+	root := mustParseYAML(t, string(out))
+	top := root.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil || len(schemasNode.Content)/2 != 2 {
+		t.Fatalf("expected exactly 2 bundled components (Foo and its transitive Bar), got %v", schemasNode)
+	}
+}
+
+func TestBundleDeduplicatesSharedExternalRef(t *testing.T) {
+	dir := t.TempDir()
+
+	mainPath := filepath.Join(dir, "main.yaml")
+	fooPath := filepath.Join(dir, "foo.yaml")
+
+	if err := os.WriteFile(mainPath, []byte(`
+openapi: 3.0.3
+info:
+  title: test
+  version: "1.0"
+paths:
+  /widgets/{id}:
+    get:
+      operationId: get_widget
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "./foo.yaml#/Foo"
+  /widgets/{id}/alt:
+    get:
+      operationId: get_widget_alt
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: "./foo.yaml#/Foo"
+`), 0o644); err != nil {
+		t.Fatalf("write main.yaml: %v", err)
+	}
+	if err := os.WriteFile(fooPath, []byte(`
+Foo:
+  type: object
+  properties:
+    id:
+      type: string
+`), 0o644); err != nil {
+		t.Fatalf("write foo.yaml: %v", err)
+	}
+
+	outPath := filepath.Join(dir, "out.yaml")
+	if err := BundleFile(mainPath, outPath, Options{}); err != nil {
+		t.Fatalf("BundleFile: %v", err)
+	}
+
+	out, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+
+	root := mustParseYAML(t, string(out))
+	top := root.Content[0]
+	schemasNode := getMapValue(getMapValue(top, "components"), "schemas")
+	if schemasNode == nil || len(schemasNode.Content)/2 != 1 {
+		t.Fatalf("expected exactly 1 bundled component shared by both responses, got %v", schemasNode)
+	}
+	if got := strings.Count(string(out), "#/components/schemas/Foo"); got != 2 {
+		t.Fatalf("expected both responses to reference the same shared component (2 refs to Foo), got %d:\n%s", got, out)
+	}
+}
+
+func TestBundleNameFromRef(t *testing.T) {
+	cases := map[string]string{
+		"./schemas/foo.yaml#/Foo":           "Foo",
+		"../common/bar.yaml#/components/Bar": "Bar",
+		"https://example.com/common.yaml":    "Common",
+		"./schemas/widget.json":              "Widget",
+	}
+	for ref, want := range cases {
+		if got := bundleNameFromRef(ref); got != want {
+			t.Errorf("bundleNameFromRef(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestEnsureUniqueComponentName(t *testing.T) {
+	existing := map[string]struct{}{"Foo": {}, "Foo2": {}}
+	if got := ensureUniqueComponentName("Foo", existing); got != "Foo3" {
+		t.Errorf("ensureUniqueComponentName(%q) = %q, want %q", "Foo", got, "Foo3")
+	}
+	if got := ensureUniqueComponentName("Bar", existing); got != "Bar" {
+		t.Errorf("ensureUniqueComponentName(%q) = %q, want %q", "Bar", got, "Bar")
+	}
+}